@@ -0,0 +1,82 @@
+package ibmcloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Artifact represents an IBM Cloud machine image produced by a build.
+type Artifact struct {
+	imageName      string
+	imageId        string
+	imageCrn       string
+	datacenterName string
+	client         SoftlayerClient
+
+	// datacenterImages maps datacenter -> image ID for every datacenter the
+	// image was distributed to via UploadToDatacenters. Empty unless
+	// stepDistributeImage ran.
+	datacenterImages map[string]string
+}
+
+// BuilderId returns the builder ID that produced this artifact.
+func (self *Artifact) BuilderId() string {
+	return BuilderId
+}
+
+func (self *Artifact) Files() []string {
+	return nil
+}
+
+// Id returns the image ID. When the image was distributed to multiple
+// datacenters, it returns every "datacenter:imageId" pair instead, sorted by
+// datacenter name, e.g. "ams01:abc,dal10:def".
+func (self *Artifact) Id() string {
+	if len(self.datacenterImages) == 0 {
+		return self.imageId
+	}
+
+	pairs := make([]string, 0, len(self.datacenterImages))
+	for datacenter, imageId := range self.datacenterImages {
+		pairs = append(pairs, fmt.Sprintf("%s:%s", datacenter, imageId))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (self *Artifact) String() string {
+	return fmt.Sprintf("%s (%s) in %s", self.imageName, self.imageId, self.datacenterName)
+}
+
+func (self *Artifact) State(name string) interface{} {
+	switch name {
+	case "atlas.artifact.metadata":
+		metadata := map[string]interface{}{
+			"image_name": self.imageName,
+			"image_id":   self.imageId,
+			"image_crn":  self.imageCrn,
+			"datacenter": self.datacenterName,
+		}
+
+		if len(self.datacenterImages) > 0 {
+			perRegion := make(map[string]map[string]string, len(self.datacenterImages))
+			for datacenter, imageId := range self.datacenterImages {
+				perRegion[datacenter] = map[string]string{
+					"image_name": self.imageName,
+					"image_id":   imageId,
+				}
+			}
+			metadata["regions"] = perRegion
+		}
+
+		return metadata
+	}
+
+	return nil
+}
+
+func (self *Artifact) Destroy() error {
+	return nil
+}
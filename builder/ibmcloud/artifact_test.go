@@ -0,0 +1,37 @@
+package ibmcloud
+
+import "testing"
+
+func TestArtifactId(t *testing.T) {
+	tests := []struct {
+		name     string
+		artifact *Artifact
+		want     string
+	}{
+		{
+			name:     "single image with no datacenterImages returns the plain imageId",
+			artifact: &Artifact{imageId: "abc123"},
+			want:     "abc123",
+		},
+		{
+			name: "multiple datacenters return a sorted comma-joined pair list",
+			artifact: &Artifact{
+				imageId: "abc123",
+				datacenterImages: map[string]string{
+					"dal10": "def456",
+					"ams01": "abc123",
+					"sjc03": "ghi789",
+				},
+			},
+			want: "ams01:abc123,dal10:def456,sjc03:ghi789",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.artifact.Id(); got != tt.want {
+				t.Errorf("Id() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package ibmcloud
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"time"
 
@@ -25,14 +27,32 @@ type Config struct {
 	Comm                  communicator.Config `mapstructure:",squash"`
 	config.KeyValueFilter `mapstructure:",squash"`
 
-	Username            string   `mapstructure:"username"`
-	APIKey              string   `mapstructure:"api_key"`
-	ImageName           string   `mapstructure:"image_name"`
-	ImageDescription    string   `mapstructure:"image_description"`
-	ImageType           string   `mapstructure:"image_type"`
-	BaseImageId         string   `mapstructure:"base_image_id"`
-	BaseOsCode          string   `mapstructure:"base_os_code"`
-	UploadToDatacenters []string `mapstructure:"upload_to_datacenters"`
+	Username             string           `mapstructure:"username"`
+	APIKey               string           `mapstructure:"api_key"`
+	ImageName            string           `mapstructure:"image_name"`
+	ImageDescription     string           `mapstructure:"image_description"`
+	ImageType            string           `mapstructure:"image_type"`
+	BaseImageId          string           `mapstructure:"base_image_id"`
+	BaseOsCode           string           `mapstructure:"base_os_code"`
+	BaseImageFilter      *BaseImageFilter `mapstructure:"base_image_filter"`
+	UploadToDatacenters  []string         `mapstructure:"upload_to_datacenters"`
+	MaxParallelTransfers int              `mapstructure:"max_parallel_transfers"`
+
+	// Platform selects between the classic SoftLayer infrastructure and
+	// the VPC Gen2 (IAM-based) infrastructure. Defaults to PLATFORM_CLASSIC.
+	Platform string `mapstructure:"platform"`
+
+	// VPC-only configuration. Only consulted when Platform == PLATFORM_VPC.
+	IBMCloudApiKey   string   `mapstructure:"ibmcloud_api_key"`
+	VpcId            string   `mapstructure:"vpc_id"`
+	SubnetId         string   `mapstructure:"subnet_id"`
+	ResourceGroupId  string   `mapstructure:"resource_group_id"`
+	Region           string   `mapstructure:"region"`
+	Zone             string   `mapstructure:"zone"`
+	InstanceProfile  string   `mapstructure:"instance_profile"`
+	SecurityGroupIds []string `mapstructure:"security_group_ids"`
+	ImageId          string   `mapstructure:"image_id"`
+	ImageNamePattern string   `mapstructure:"image_name_pattern"`
 
 	InstanceName                   string  `mapstructure:"instance_name"`
 	InstanceDomain                 string  `mapstructure:"instance_domain"`
@@ -47,9 +67,34 @@ type Config struct {
 	ProvisioningSshKeyId           int64   `mapstructure:"provisioning_ssh_key_id"`
 	InstancePublicSecurityGroupIds []int64 `mapstructure:"public_security_groups"`
 
+	// SshInterface selects which instance address the communicator connects
+	// through: "public" (default), "private", or "private_v6".
+	SshInterface string `mapstructure:"ssh_interface"`
+
+	// UserData/UserDataFile are passed through to the instance's cloud-init
+	// metadata service. Mutually exclusive; UserDataFile's contents replace
+	// UserData once Prepare has run.
+	UserData     string `mapstructure:"user_data"`
+	UserDataFile string `mapstructure:"user_data_file"`
+
+	// WaitForCloudInit makes stepWaitForCloudInit block until cloud-init
+	// reports it has finished before the image is captured. Most useful
+	// with communicator = "none", where provisioning is entirely
+	// cloud-init-driven.
+	WaitForCloudInit bool `mapstructure:"wait_for_cloud_init"`
+
 	RawStateTimeout string `mapstructure:"instance_state_timeout"`
 	StateTimeout    time.Duration
 
+	// CleanupProvisioner controls whether onErrorRunner runs the build's
+	// cleanup provisioner (a provisioner with only_on = ["error"], or one
+	// invoked via "packer build -on-error=run-cleanup-provisioner") against
+	// the instance when a step fails, before the instance is torn down.
+	// Defaults to true; set to false to skip it even if the template or
+	// -on-error flag asked for one. A *bool so Prepare can tell "unset" from
+	// "explicitly disabled".
+	CleanupProvisioner *bool `mapstructure:"cleanup_provisioner"`
+
 	ctx interpolate.Context
 }
 
@@ -57,6 +102,15 @@ type Config struct {
 //const IMAGE_TYPE_FLEX = "flex" //----NOT SUPPORTED
 const IMAGE_TYPE_STANDARD = "standard"
 
+// Platforms
+const PLATFORM_CLASSIC = "classic"
+const PLATFORM_VPC = "vpc"
+
+// SSH interfaces
+const SSH_INTERFACE_PUBLIC = "public"
+const SSH_INTERFACE_PRIVATE = "private"
+const SSH_INTERFACE_PRIVATE_V6 = "private_v6"
+
 // Builder represents a Packer Builder.
 type Builder struct {
 	config Config
@@ -79,6 +133,14 @@ func (self *Builder) Prepare(raws ...interface{}) (parms []string, param2 []stri
 		return nil, nil, err
 	}
 
+	if self.config.Platform == "" {
+		self.config.Platform = PLATFORM_CLASSIC
+	}
+
+	if self.config.SshInterface == "" {
+		self.config.SshInterface = SSH_INTERFACE_PUBLIC
+	}
+
 	// Assign default values if possible
 	if self.config.DatacenterName == "" {
 		self.config.DatacenterName = "ams01"
@@ -104,10 +166,31 @@ func (self *Builder) Prepare(raws ...interface{}) (parms []string, param2 []stri
 		self.config.InstanceNetworkSpeed = 10
 	}
 
+	if self.config.CleanupProvisioner == nil {
+		enabled := true
+		self.config.CleanupProvisioner = &enabled
+	}
+
 	if self.config.RawStateTimeout == "" {
 		self.config.RawStateTimeout = "10m"
 	}
 
+	if self.config.MaxParallelTransfers == 0 {
+		self.config.MaxParallelTransfers = 4
+	}
+
+	if self.config.UserData != "" && self.config.UserDataFile != "" {
+		return nil, nil, errors.New("please specify only one of user_data or user_data_file")
+	}
+
+	if self.config.UserDataFile != "" {
+		contents, err := ioutil.ReadFile(self.config.UserDataFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("user_data_file: %s", err)
+		}
+		self.config.UserData = string(contents)
+	}
+
 	if self.config.Comm.Type == "winrm" {
 		if self.config.Comm.WinRMUser == "" {
 			self.config.Comm.WinRMUser = "Administrator"
@@ -123,54 +206,124 @@ func (self *Builder) Prepare(raws ...interface{}) (parms []string, param2 []stri
 	var errs *packer.MultiError
 	errs = packer.MultiErrorAppend(errs, self.config.Comm.Prepare(&self.config.ctx)...)
 
-	if self.config.InstanceCpu > 0 {
-		byFlavor = false
-	}
-
-	if self.config.InstanceMemory > 0 {
-		byFlavor = false
-	}
-
-	if self.config.InstanceDiskCapacity > 0 {
-		byFlavor = false
-	}
-
-	if !byFlavor && self.config.InstanceFlavor != "" {
-		errs = packer.MultiErrorAppend(
-			errs, errors.New("instance_flavor must be specified without instance_cpu, instance_memory, and instance_disk_capacity"))
-	} else if byFlavor && self.config.InstanceFlavor == "" {
+	if self.config.ImageName == "" {
 		errs = packer.MultiErrorAppend(
-			errs, errors.New("instance_flavor must be specified"))
+			errs, errors.New("image_name must be specified"))
 	}
 
-	if self.config.APIKey == "" {
+	switch self.config.SshInterface {
+	case SSH_INTERFACE_PUBLIC, SSH_INTERFACE_PRIVATE, SSH_INTERFACE_PRIVATE_V6:
+	default:
 		errs = packer.MultiErrorAppend(
-			errs, errors.New("api_key or the SOFTLAYER_API_KEY environment variable must be specified"))
+			errs, fmt.Errorf("ssh_interface must be one of \"public\", \"private\", or \"private_v6\", got %q", self.config.SshInterface))
 	}
 
-	if self.config.Username == "" {
+	if encoded := base64.StdEncoding.EncodeToString([]byte(self.config.UserData)); len(encoded) > 16*1024 {
 		errs = packer.MultiErrorAppend(
-			errs, errors.New("username or the SOFTLAYER_USER_NAME environment variable must be specified"))
+			errs, fmt.Errorf("user_data exceeds SoftLayer's 16KB limit once base64 encoded (%d bytes)", len(encoded)))
 	}
 
-	if self.config.ImageName == "" {
-		errs = packer.MultiErrorAppend(
-			errs, errors.New("image_name must be specified"))
-	}
+	if self.config.Platform == PLATFORM_VPC {
+		if self.config.IBMCloudApiKey == "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("ibmcloud_api_key must be specified when platform is \"vpc\""))
+		}
 
-	if self.config.ImageType != IMAGE_TYPE_STANDARD {
-		errs = packer.MultiErrorAppend(
-			errs, fmt.Errorf("Unknown image_type '%s'. Must be 'standard'.", self.config.ImageType))
-	}
+		if self.config.VpcId == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("vpc_id must be specified"))
+		}
 
-	if self.config.BaseImageId == "" && self.config.BaseOsCode == "" {
-		errs = packer.MultiErrorAppend(
-			errs, errors.New("please specify base_image_id or base_os_code"))
-	}
+		if self.config.SubnetId == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("subnet_id must be specified"))
+		}
+
+		if self.config.Region == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("region must be specified"))
+		}
+
+		if self.config.Zone == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("zone must be specified"))
+		}
 
-	if self.config.BaseImageId != "" && self.config.BaseOsCode != "" {
+		if self.config.InstanceProfile == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("instance_profile must be specified"))
+		}
+
+		if self.config.ImageId == "" && self.config.ImageNamePattern == "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("please specify image_id or image_name_pattern"))
+		}
+
+		if self.config.ImageId != "" && self.config.ImageNamePattern != "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("please specify only one of image_id or image_name_pattern"))
+		}
+
+		if self.config.SshInterface == SSH_INTERFACE_PRIVATE_V6 {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("ssh_interface \"private_v6\" is not supported when platform is \"vpc\""))
+		}
+	} else if self.config.Platform == PLATFORM_CLASSIC {
+		if self.config.InstanceCpu > 0 {
+			byFlavor = false
+		}
+
+		if self.config.InstanceMemory > 0 {
+			byFlavor = false
+		}
+
+		if self.config.InstanceDiskCapacity > 0 {
+			byFlavor = false
+		}
+
+		if !byFlavor && self.config.InstanceFlavor != "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("instance_flavor must be specified without instance_cpu, instance_memory, and instance_disk_capacity"))
+		} else if byFlavor && self.config.InstanceFlavor == "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("instance_flavor must be specified"))
+		}
+
+		if self.config.APIKey == "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("api_key or the SOFTLAYER_API_KEY environment variable must be specified"))
+		}
+
+		if self.config.Username == "" {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("username or the SOFTLAYER_USER_NAME environment variable must be specified"))
+		}
+
+		if self.config.ImageType != IMAGE_TYPE_STANDARD {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Unknown image_type '%s'. Must be 'standard'.", self.config.ImageType))
+		}
+
+		baseImageOptions := 0
+		if self.config.BaseImageId != "" {
+			baseImageOptions++
+		}
+		if self.config.BaseOsCode != "" {
+			baseImageOptions++
+		}
+		if self.config.BaseImageFilter != nil {
+			baseImageOptions++
+		}
+
+		if baseImageOptions == 0 {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("please specify base_image_id, base_os_code, or base_image_filter"))
+		} else if baseImageOptions > 1 {
+			errs = packer.MultiErrorAppend(
+				errs, errors.New("please specify only one of base_image_id, base_os_code, or base_image_filter"))
+		}
+
+		if self.config.BaseImageFilter != nil {
+			errs = packer.MultiErrorAppend(errs, self.config.BaseImageFilter.Prepare()...)
+		}
+	} else {
 		errs = packer.MultiErrorAppend(
-			errs, errors.New("please specify only one of base_image_id or base_os_code"))
+			errs, fmt.Errorf("Unknown platform '%s'. Must be 'classic' or 'vpc'.", self.config.Platform))
 	}
 
 	stateTimeout, err := time.ParseDuration(self.config.RawStateTimeout)
@@ -193,6 +346,17 @@ func (self *Builder) Prepare(raws ...interface{}) (parms []string, param2 []stri
 // representing a SoftLayer machine image (standard).
 // func (self *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
 func (self *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
+	if self.config.Platform == PLATFORM_VPC {
+		return self.runVPC(ctx, ui, hook)
+	}
+
+	return self.runClassic(ctx, ui, hook)
+}
+
+// runClassic executes a SoftLayer Packer build against the classic
+// infrastructure and returns a packer.Artifact representing a SoftLayer
+// machine image (standard).
+func (self *Builder) runClassic(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
 
 	// Create the client
 	client := SoftlayerClient{}.New(self.config.Username, self.config.APIKey)
@@ -204,13 +368,29 @@ func (self *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (p
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 
+	// stepGrabIP grabs whichever address the configured ssh_interface points at.
+	var stepGrabIP multistep.Step = new(stepGrabPublicIP)
+	if self.config.SshInterface == SSH_INTERFACE_PRIVATE || self.config.SshInterface == SSH_INTERFACE_PRIVATE_V6 {
+		stepGrabIP = new(stepGrabPrivateIP)
+	}
+
+	// captureSteps runs once the instance is ready to be snapshotted: wait
+	// for cloud-init to finish (if requested), then capture and distribute
+	// the image.
+	captureSteps := []multistep.Step{}
+	if self.config.WaitForCloudInit {
+		captureSteps = append(captureSteps, new(stepWaitForCloudInit))
+	}
+	captureSteps = append(captureSteps, new(stepCaptureImage), new(stepDistributeImage))
+
 	// Build the steps
 	steps := []multistep.Step{}
 	if self.config.Comm.Type == "winrm" {
 		steps = []multistep.Step{
+			new(stepResolveBaseImage),
 			new(stepCreateInstance),
 			new(stepWaitforInstance),
-			new(stepGrabPublicIP),
+			stepGrabIP,
 			&communicator.StepConnect{
 				Config:      &self.config.Comm,
 				Host:        winRMCommHost,
@@ -218,28 +398,41 @@ func (self *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (p
 			},
 			new(stepWaitforInstance),
 			new(commonsteps.StepProvision),
-			new(stepCaptureImage),
 		}
+		steps = append(steps, captureSteps...)
 	} else if self.config.Comm.Type == "ssh" {
 		steps = []multistep.Step{
 			&stepCreateSshKey{
 				PrivateKeyFile: string(self.config.Comm.SSHPrivateKey),
 			},
+			new(stepResolveBaseImage),
 			new(stepCreateInstance),
 			new(stepWaitforInstance),
-			new(stepGrabPublicIP),
+			stepGrabIP,
 			&communicator.StepConnect{
 				Config:    &self.config.Comm,
 				Host:      sshCommHost,
 				SSHConfig: sshConfig,
 			},
 			new(commonsteps.StepProvision),
-			new(stepCaptureImage),
 		}
+		steps = append(steps, captureSteps...)
+	} else if self.config.Comm.Type == "none" {
+		// No communicator means nothing to connect to or provision over;
+		// just create the instance and capture it as-is (e.g. after
+		// cloud-init provisioning).
+		steps = []multistep.Step{
+			new(stepResolveBaseImage),
+			new(stepCreateInstance),
+			new(stepWaitforInstance),
+		}
+		steps = append(steps, captureSteps...)
 	}
 
-	// Create the runner which will run the steps we just build
-	self.runner = &multistep.BasicRunner{Steps: steps}
+	// Create the runner which will run the steps we just build. onErrorRunner
+	// gives the build's cleanup provisioner (if any) a chance to run against
+	// the instance before teardown, and honors PACKER_ON_ERROR=abort.
+	self.runner = &onErrorRunner{steps: steps, cleanupProvisioner: *self.config.CleanupProvisioner}
 	self.runner.Run(ctx, state)
 
 	// If there was an error, return that
@@ -260,6 +453,86 @@ func (self *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (p
 		client:         client,
 	}
 
+	if rawImages, ok := state.GetOk("datacenter_images"); ok {
+		artifact.datacenterImages = rawImages.(map[string]string)
+	}
+
+	return artifact, nil
+}
+
+// runVPC executes a Packer build against the IBM Cloud VPC Gen2
+// infrastructure and returns a packer.Artifact representing the resulting
+// custom image.
+func (self *Builder) runVPC(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
+	client, err := VPCClient{}.New(self.config.IBMCloudApiKey, self.config.Region)
+	if err != nil {
+		return nil, fmt.Errorf("Error authenticating against IBM Cloud IAM: %s", err)
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", self.config)
+	state.Put("vpcClient", client)
+	state.Put("hook", hook)
+	state.Put("ui", ui)
+
+	// stepGrabVPCIP grabs whichever address ssh_interface points at: a newly
+	// reserved floating IP (public, the default), or the instance's existing
+	// private IP (private, no floating IP reserved at all).
+	var stepGrabVPCIP multistep.Step = new(stepAttachFloatingIP)
+	if self.config.SshInterface == SSH_INTERFACE_PRIVATE {
+		stepGrabVPCIP = new(stepGrabVPCPrivateIP)
+	}
+
+	steps := []multistep.Step{
+		new(stepResolveVPCImage),
+		new(stepCreateVPCInstance),
+		new(stepWaitForVPCInstance),
+		stepGrabVPCIP,
+	}
+
+	if self.config.Comm.Type == "winrm" {
+		steps = append(steps,
+			&communicator.StepConnect{
+				Config:      &self.config.Comm,
+				Host:        vpcCommHost,
+				WinRMConfig: winRMConfig,
+			},
+			new(commonsteps.StepProvision),
+		)
+	} else if self.config.Comm.Type == "ssh" {
+		steps = append(steps,
+			&communicator.StepConnect{
+				Config:    &self.config.Comm,
+				Host:      vpcCommHost,
+				SSHConfig: sshConfig,
+			},
+			new(commonsteps.StepProvision),
+		)
+	}
+	// communicator = "none": nothing to connect to or provision over; fall
+	// straight through to capturing the instance as-is.
+
+	steps = append(steps, new(stepCaptureVPCImage))
+
+	self.runner = &multistep.BasicRunner{Steps: steps}
+	self.runner.Run(ctx, state)
+
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	if _, ok := state.GetOk("image_id"); !ok {
+		log.Println("Failed to find image_id in state. Bug?")
+		return nil, nil
+	}
+
+	artifact := &Artifact{
+		imageName:      self.config.ImageName,
+		imageId:        state.Get("image_id").(string),
+		imageCrn:       state.Get("image_crn").(string),
+		datacenterName: self.config.Zone,
+	}
+
 	return artifact, nil
 }
 
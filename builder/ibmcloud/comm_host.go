@@ -0,0 +1,25 @@
+package ibmcloud
+
+import "github.com/hashicorp/packer-plugin-sdk/multistep"
+
+// sshCommHost returns the address the SSH communicator should dial,
+// honoring the configured ssh_interface.
+func sshCommHost(state multistep.StateBag) (string, error) {
+	return commHost(state)
+}
+
+// winRMCommHost returns the address the WinRM communicator should dial,
+// honoring the configured ssh_interface.
+func winRMCommHost(state multistep.StateBag) (string, error) {
+	return commHost(state)
+}
+
+func commHost(state multistep.StateBag) (string, error) {
+	config := state.Get("config").(Config)
+
+	if config.SshInterface == SSH_INTERFACE_PRIVATE || config.SshInterface == SSH_INTERFACE_PRIVATE_V6 {
+		return state.Get("private_ip").(string), nil
+	}
+
+	return state.Get("public_ip").(string), nil
+}
@@ -0,0 +1,81 @@
+package ibmcloud
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// onErrorRunner replaces the plain multistep.BasicRunner classic builds used
+// to run with. On a failed step it gives the build's cleanup provisioner
+// (wired through the "hook" state key that Packer core populates from
+// "-on-error"/error-cleanup-provisioner) a chance to run against the
+// instance while its communicator is still connected, before each step's own
+// Cleanup tears it down, unless Config.CleanupProvisioner disabled it. It
+// also honors PACKER_ON_ERROR=abort by skipping teardown entirely so the
+// instance can be inspected.
+type onErrorRunner struct {
+	steps []multistep.Step
+
+	// cleanupProvisioner mirrors Config.CleanupProvisioner; when false,
+	// runCleanupProvisioner is a no-op even if a cleanup provisioner was
+	// configured at the template level.
+	cleanupProvisioner bool
+}
+
+func (self *onErrorRunner) Run(ctx context.Context, state multistep.StateBag) {
+	for i, step := range self.steps {
+		if action := step.Run(ctx, state); action == multistep.ActionHalt {
+			self.runCleanupProvisioner(ctx, state)
+			self.teardown(state, self.steps[:i+1])
+			return
+		}
+	}
+
+	self.teardown(state, self.steps)
+}
+
+func (self *onErrorRunner) Cancel() {}
+
+// runCleanupProvisioner invokes the build's cleanup provisioner against the
+// still-live instance, if a communicator is connected, the build failed, and
+// Config.CleanupProvisioner hasn't disabled this.
+func (self *onErrorRunner) runCleanupProvisioner(ctx context.Context, state multistep.StateBag) {
+	if !self.cleanupProvisioner {
+		return
+	}
+
+	if _, ok := state.GetOk("error"); !ok {
+		return
+	}
+
+	rawComm, ok := state.GetOk("communicator")
+	if !ok {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	hook := state.Get("hook").(packer.Hook)
+
+	ui.Say("Running the cleanup provisioner, if any...")
+	if err := hook.Run(ctx, packer.HookCleanupProvisioner, ui, rawComm.(packer.Communicator), nil); err != nil {
+		ui.Error(fmt.Sprintf("Error running cleanup provisioner: %s", err))
+	}
+}
+
+// teardown runs each already-executed step's Cleanup in reverse order,
+// unless PACKER_ON_ERROR=abort asked to leave the instance as-is.
+func (self *onErrorRunner) teardown(state multistep.StateBag, ran []multistep.Step) {
+	if _, ok := state.GetOk("error"); ok && os.Getenv("PACKER_ON_ERROR") == "abort" {
+		state.Get("ui").(packer.Ui).Say(
+			"PACKER_ON_ERROR=abort: leaving the instance running for inspection, skipping cleanup")
+		return
+	}
+
+	for i := len(ran) - 1; i >= 0; i-- {
+		ran[i].Cleanup(state)
+	}
+}
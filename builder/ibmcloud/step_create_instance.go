@@ -0,0 +1,89 @@
+package ibmcloud
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// softlayerInstance is the subset of SoftLayer_Virtual_Guest this builder cares about.
+type softlayerInstance struct {
+	Id                        int    `json:"id"`
+	PrimaryIpAddress          string `json:"primaryIpAddress"`
+	PrimaryBackendIpAddress   string `json:"primaryBackendIpAddress"`
+	PrimaryBackendIpv6Address string `json:"primaryBackendIpv6Address"`
+}
+
+// stepCreateInstance provisions the SoftLayer_Virtual_Guest that will be
+// snapshotted into the resulting image.
+type stepCreateInstance struct {
+	instanceId string
+}
+
+func (self *stepCreateInstance) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("client").(SoftlayerClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Creating instance %s...", config.InstanceName))
+
+	template := map[string]interface{}{
+		"hostname":      config.InstanceName,
+		"domain":        config.InstanceDomain,
+		"datacenter":    map[string]string{"name": config.DatacenterName},
+		"localDiskFlag": config.InstanceLocalDiskFlag,
+		"networkComponents": []map[string]interface{}{
+			{"maxSpeed": config.InstanceNetworkSpeed},
+		},
+	}
+
+	if config.InstanceFlavor != "" {
+		template["supplementalCreateObjectOptions"] = map[string]string{"flavorKeyName": config.InstanceFlavor}
+	} else {
+		template["startCpus"] = config.InstanceCpu
+		template["maxMemory"] = config.InstanceMemory
+		template["localDiskFlag"] = config.InstanceLocalDiskFlag
+	}
+
+	if config.BaseImageId != "" {
+		template["blockDeviceTemplateGroup"] = map[string]string{"globalIdentifier": config.BaseImageId}
+	} else {
+		template["operatingSystemReferenceCode"] = config.BaseOsCode
+	}
+
+	if config.UserData != "" {
+		template["userData"] = []map[string]string{
+			{"value": base64.StdEncoding.EncodeToString([]byte(config.UserData))},
+		}
+	}
+
+	instance, err := client.CreateInstance(template)
+	if err != nil {
+		err = fmt.Errorf("Error creating instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	self.instanceId = fmt.Sprintf("%d", instance.Id)
+	state.Put("instance_id", self.instanceId)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepCreateInstance) Cleanup(state multistep.StateBag) {
+	if self.instanceId == "" {
+		return
+	}
+
+	client := state.Get("client").(SoftlayerClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say("Deleting instance...")
+	if err := client.DeleteInstance(self.instanceId); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting instance: %s", err))
+	}
+}
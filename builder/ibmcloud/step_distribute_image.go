@@ -0,0 +1,84 @@
+package ibmcloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepDistributeImage transfers the captured template to every datacenter in
+// UploadToDatacenters, in parallel bounded by MaxParallelTransfers, and
+// records the resulting per-datacenter image IDs in state so the Artifact
+// can expose all of them.
+type stepDistributeImage struct{}
+
+func (self *stepDistributeImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+
+	if len(config.UploadToDatacenters) == 0 {
+		return multistep.ActionContinue
+	}
+
+	client := state.Get("client").(SoftlayerClient)
+	ui := state.Get("ui").(packer.Ui)
+	imageId := state.Get("image_id").(string)
+
+	ui.Say(fmt.Sprintf("Distributing image to %d datacenter(s)...", len(config.UploadToDatacenters)))
+
+	semaphore := make(chan struct{}, config.MaxParallelTransfers)
+	results := make(map[string]string, len(config.UploadToDatacenters))
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, datacenter := range config.UploadToDatacenters {
+		wg.Add(1)
+		go func(datacenter string) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			transactionId, err := client.AddLocation(imageId, datacenter)
+			if err != nil {
+				mu.Lock()
+				errs[datacenter] = fmt.Errorf("error adding location %s: %s", datacenter, err)
+				mu.Unlock()
+				return
+			}
+
+			err = waitFor(ctx, config.StateTimeout, func() (bool, error) {
+				return client.TransactionComplete(transactionId)
+			})
+			if err != nil {
+				mu.Lock()
+				errs[datacenter] = fmt.Errorf("error waiting for transfer to %s: %s", datacenter, err)
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			results[datacenter] = imageId
+			mu.Unlock()
+			ui.Say(fmt.Sprintf("Image transferred to %s", datacenter))
+		}(datacenter)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		err := fmt.Errorf("failed to distribute image to %d datacenter(s): %v", len(errs), errs)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("datacenter_images", results)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepDistributeImage) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,41 @@
+package ibmcloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepGrabPrivateIP reads the instance's private backend IP so the
+// communicator can reach it over the SoftLayer private network (or VPN),
+// instead of the public IP grabbed by stepGrabPublicIP.
+type stepGrabPrivateIP struct{}
+
+func (self *stepGrabPrivateIP) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("client").(SoftlayerClient)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("instance_id").(string)
+
+	ui.Say("Looking up instance's private IP...")
+
+	instance, err := client.GetInstance(instanceId)
+	if err != nil {
+		err = fmt.Errorf("Error fetching instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if config.SshInterface == SSH_INTERFACE_PRIVATE_V6 {
+		state.Put("private_ip", instance.PrimaryBackendIpv6Address)
+	} else {
+		state.Put("private_ip", instance.PrimaryBackendIpAddress)
+	}
+
+	return multistep.ActionContinue
+}
+
+func (self *stepGrabPrivateIP) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,145 @@
+package ibmcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// BaseImageFilter lets users select a base image dynamically instead of
+// hardcoding a base_image_id that may expire.
+type BaseImageFilter struct {
+	NameRegex       string `mapstructure:"name_regex"`
+	OperatingSystem string `mapstructure:"operating_system"`
+	// Owner is either "public" or "private". Defaults to "private".
+	Owner      string `mapstructure:"owner"`
+	Visibility string `mapstructure:"visibility"`
+	MostRecent bool   `mapstructure:"most_recent"`
+}
+
+const imageOwnerPublic = "public"
+const imageOwnerPrivate = "private"
+
+// Prepare validates the filter and fills in defaults. It mirrors the
+// Config.Prepare convention of returning a slice of errors to append.
+func (self *BaseImageFilter) Prepare() []error {
+	var errs []error
+
+	if self.Owner == "" {
+		self.Owner = imageOwnerPrivate
+	}
+
+	if self.Owner != imageOwnerPublic && self.Owner != imageOwnerPrivate {
+		errs = append(errs, fmt.Errorf("base_image_filter.owner must be \"public\" or \"private\", got %q", self.Owner))
+	}
+
+	if self.NameRegex != "" {
+		if _, err := regexp.Compile(self.NameRegex); err != nil {
+			errs = append(errs, fmt.Errorf("base_image_filter.name_regex is not a valid regular expression: %s", err))
+		}
+	}
+
+	return errs
+}
+
+// softlayerBlockDeviceTemplateGroup is the subset of
+// SoftLayer_Virtual_Guest_Block_Device_Template_Group this builder cares about.
+type softlayerBlockDeviceTemplateGroup struct {
+	Id                           int    `json:"id"`
+	Name                         string `json:"name"`
+	GlobalIdentifier             string `json:"globalIdentifier"`
+	CreateDate                   string `json:"createDate"`
+	OperatingSystemReferenceCode string `json:"operatingSystemReferenceCode"`
+	Visibility                   string `json:"visibility"`
+}
+
+// stepResolveBaseImage turns a BaseImageFilter into a concrete base_image_id
+// before stepCreateInstance runs, so downstream steps don't need to change.
+type stepResolveBaseImage struct{}
+
+func (self *stepResolveBaseImage) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+
+	if config.BaseImageFilter == nil {
+		return multistep.ActionContinue
+	}
+
+	client := state.Get("client").(SoftlayerClient)
+	ui := state.Get("ui").(packer.Ui)
+	filter := config.BaseImageFilter
+
+	ui.Say("Resolving base_image_filter to a base image...")
+
+	var candidates []softlayerBlockDeviceTemplateGroup
+	var err error
+	if filter.Owner == imageOwnerPublic {
+		candidates, err = client.GetPublicImages()
+	} else {
+		candidates, err = client.GetPrivateImages()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error listing base images: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	resolved, err := resolveBaseImage(candidates, filter)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("Resolved base_image_filter to %s (%s)", resolved.Name, resolved.GlobalIdentifier))
+
+	config.BaseImageId = resolved.GlobalIdentifier
+	state.Put("config", config)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepResolveBaseImage) Cleanup(multistep.StateBag) {}
+
+// resolveBaseImage filters candidates down to the single image a
+// BaseImageFilter selects. Split out from stepResolveBaseImage.Run so it can
+// be unit tested without a SoftlayerClient.
+func resolveBaseImage(candidates []softlayerBlockDeviceTemplateGroup, filter *BaseImageFilter) (*softlayerBlockDeviceTemplateGroup, error) {
+	var nameRe *regexp.Regexp
+	if filter.NameRegex != "" {
+		nameRe = regexp.MustCompile(filter.NameRegex)
+	}
+
+	var matched []softlayerBlockDeviceTemplateGroup
+	for _, candidate := range candidates {
+		if nameRe != nil && !nameRe.MatchString(candidate.Name) {
+			continue
+		}
+		if filter.OperatingSystem != "" && candidate.OperatingSystemReferenceCode != filter.OperatingSystem {
+			continue
+		}
+		if filter.Visibility != "" && candidate.Visibility != filter.Visibility {
+			continue
+		}
+		matched = append(matched, candidate)
+	}
+
+	if len(matched) == 0 {
+		return nil, errors.New("base_image_filter matched no images")
+	}
+
+	if filter.MostRecent {
+		sort.Slice(matched, func(i, j int) bool {
+			return matched[i].CreateDate > matched[j].CreateDate
+		})
+	} else if len(matched) > 1 {
+		return nil, fmt.Errorf("base_image_filter matched %d images; set most_recent to pick one automatically", len(matched))
+	}
+
+	return &matched[0], nil
+}
@@ -0,0 +1,76 @@
+package ibmcloud
+
+import "testing"
+
+func TestResolveBaseImage(t *testing.T) {
+	candidates := []softlayerBlockDeviceTemplateGroup{
+		{Name: "ubuntu-20-04", GlobalIdentifier: "ubuntu-old", CreateDate: "2023-01-01", OperatingSystemReferenceCode: "UBUNTU_20_64", Visibility: "public"},
+		{Name: "ubuntu-20-04", GlobalIdentifier: "ubuntu-new", CreateDate: "2023-06-01", OperatingSystemReferenceCode: "UBUNTU_20_64", Visibility: "public"},
+		{Name: "ubuntu-22-04", GlobalIdentifier: "ubuntu-22", CreateDate: "2023-03-01", OperatingSystemReferenceCode: "UBUNTU_22_64", Visibility: "private"},
+		{Name: "centos-7", GlobalIdentifier: "centos-7", CreateDate: "2022-01-01", OperatingSystemReferenceCode: "CENTOS_7_64", Visibility: "public"},
+	}
+
+	tests := []struct {
+		name       string
+		filter     *BaseImageFilter
+		wantId     string
+		wantErr    bool
+		errPattern string
+	}{
+		{
+			name:   "name_regex narrows to one match",
+			filter: &BaseImageFilter{NameRegex: "^centos-"},
+			wantId: "centos-7",
+		},
+		{
+			name:    "name_regex matching multiple without most_recent errors",
+			filter:  &BaseImageFilter{NameRegex: "^ubuntu-20"},
+			wantErr: true,
+		},
+		{
+			name:   "most_recent picks the newest match",
+			filter: &BaseImageFilter{NameRegex: "^ubuntu-20", MostRecent: true},
+			wantId: "ubuntu-new",
+		},
+		{
+			name:   "operating_system filters independently of name_regex",
+			filter: &BaseImageFilter{OperatingSystem: "UBUNTU_22_64"},
+			wantId: "ubuntu-22",
+		},
+		{
+			name:   "visibility filters independently of name_regex",
+			filter: &BaseImageFilter{Visibility: "private"},
+			wantId: "ubuntu-22",
+		},
+		{
+			name:    "no match errors",
+			filter:  &BaseImageFilter{OperatingSystem: "RHEL_9_64"},
+			wantErr: true,
+		},
+		{
+			name:   "operating_system and visibility compose",
+			filter: &BaseImageFilter{OperatingSystem: "UBUNTU_20_64", Visibility: "public", MostRecent: true},
+			wantId: "ubuntu-new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := resolveBaseImage(candidates, tt.filter)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveBaseImage() = %+v, want error", resolved)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("resolveBaseImage() unexpected error: %s", err)
+			}
+			if resolved.GlobalIdentifier != tt.wantId {
+				t.Errorf("resolveBaseImage() = %q, want %q", resolved.GlobalIdentifier, tt.wantId)
+			}
+		})
+	}
+}
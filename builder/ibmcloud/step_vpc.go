@@ -0,0 +1,315 @@
+package ibmcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// vpcCommHost returns the address the communicator should use to reach a
+// VPC instance: the floating IP attached by stepAttachFloatingIP, or the
+// primary network interface's private IP grabbed by stepGrabVPCPrivateIP
+// when ssh_interface = "private".
+func vpcCommHost(state multistep.StateBag) (string, error) {
+	config := state.Get("config").(Config)
+
+	if config.SshInterface == SSH_INTERFACE_PRIVATE {
+		return state.Get("vpc_private_ip").(string), nil
+	}
+
+	return state.Get("vpc_public_ip").(string), nil
+}
+
+// stepGrabVPCPrivateIP reads the instance's primary network interface
+// private IP, used instead of stepAttachFloatingIP when ssh_interface =
+// "private" so no floating IP is reserved at all.
+type stepGrabVPCPrivateIP struct{}
+
+func (self *stepGrabVPCPrivateIP) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("vpc_instance_id").(string)
+
+	ui.Say("Looking up VPC instance's private IP...")
+
+	instance, err := client.GetInstance(instanceId)
+	if err != nil {
+		err = fmt.Errorf("Error fetching VPC instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("vpc_private_ip", instance.PrimaryNetworkInterface.PrimaryIP.Address)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepGrabVPCPrivateIP) Cleanup(multistep.StateBag) {}
+
+// stepResolveVPCImage turns image_name_pattern into a concrete image_id
+// before stepCreateVPCInstance runs, picking the most recently created image
+// whose name matches. A no-op when image_id was set directly.
+type stepResolveVPCImage struct{}
+
+func (self *stepResolveVPCImage) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+
+	if config.ImageNamePattern == "" {
+		return multistep.ActionContinue
+	}
+
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say("Resolving image_name_pattern to a VPC image...")
+
+	images, err := client.ListImages()
+	if err != nil {
+		err = fmt.Errorf("Error listing VPC images: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	re, err := regexp.Compile(config.ImageNamePattern)
+	if err != nil {
+		err = fmt.Errorf("Error compiling image_name_pattern: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	var matched []VPCImage
+	for _, image := range images {
+		if re.MatchString(image.Name) {
+			matched = append(matched, image)
+		}
+	}
+
+	if len(matched) == 0 {
+		err = errors.New("image_name_pattern matched no images")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].CreatedAt > matched[j].CreatedAt
+	})
+
+	resolved := matched[0]
+	ui.Say(fmt.Sprintf("Resolved image_name_pattern to %s (%s)", resolved.Name, resolved.Id))
+
+	config.ImageId = resolved.Id
+	state.Put("config", config)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepResolveVPCImage) Cleanup(multistep.StateBag) {}
+
+// stepCreateVPCInstance creates the VPC virtual server instance that will be
+// provisioned and captured.
+type stepCreateVPCInstance struct {
+	instanceId string
+}
+
+func (self *stepCreateVPCInstance) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say(fmt.Sprintf("Creating VPC instance %s...", config.InstanceName))
+
+	securityGroups := make([]map[string]string, len(config.SecurityGroupIds))
+	for i, id := range config.SecurityGroupIds {
+		securityGroups[i] = map[string]string{"id": id}
+	}
+
+	imageRef := map[string]string{}
+	if config.ImageId != "" {
+		imageRef["id"] = config.ImageId
+	}
+
+	params := map[string]interface{}{
+		"name":    config.InstanceName,
+		"image":   imageRef,
+		"profile": map[string]string{"name": config.InstanceProfile},
+		"zone":    map[string]string{"name": config.Zone},
+		"vpc":     map[string]string{"id": config.VpcId},
+		"primary_network_interface": map[string]interface{}{
+			"subnet":          map[string]string{"id": config.SubnetId},
+			"security_groups": securityGroups,
+		},
+		"resource_group": map[string]string{"id": config.ResourceGroupId},
+	}
+
+	instance, err := client.CreateInstance(params)
+	if err != nil {
+		err = fmt.Errorf("Error creating VPC instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	self.instanceId = instance.Id
+	state.Put("vpc_instance_id", instance.Id)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepCreateVPCInstance) Cleanup(state multistep.StateBag) {
+	if self.instanceId == "" {
+		return
+	}
+
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say("Deleting VPC instance...")
+	if err := client.DeleteInstance(self.instanceId); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting VPC instance: %s", err))
+	}
+}
+
+// stepWaitForVPCInstance polls the instance until it reaches the "running" status.
+type stepWaitForVPCInstance struct{}
+
+func (self *stepWaitForVPCInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("vpc_instance_id").(string)
+
+	ui.Say("Waiting for VPC instance to become running...")
+
+	err := waitFor(ctx, config.StateTimeout, func() (bool, error) {
+		instance, err := client.GetInstance(instanceId)
+		if err != nil {
+			return false, err
+		}
+		return instance.Status == "running", nil
+	})
+	if err != nil {
+		err = fmt.Errorf("Error waiting for VPC instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (self *stepWaitForVPCInstance) Cleanup(multistep.StateBag) {}
+
+// stepAttachFloatingIP reserves and attaches a floating IP to the instance's
+// primary network interface so the communicator can reach it.
+type stepAttachFloatingIP struct {
+	floatingIpId string
+}
+
+func (self *stepAttachFloatingIP) Run(_ context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("vpc_instance_id").(string)
+
+	ui.Say("Attaching floating IP to VPC instance...")
+
+	instance, err := client.GetInstance(instanceId)
+	if err != nil {
+		err = fmt.Errorf("Error fetching VPC instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ip, err := client.CreateFloatingIP(config.Zone)
+	if err != nil {
+		err = fmt.Errorf("Error creating floating IP: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	self.floatingIpId = ip.Id
+
+	if err := client.AttachFloatingIP(instanceId, instance.PrimaryNetworkInterface.Id, ip.Id); err != nil {
+		err = fmt.Errorf("Error attaching floating IP: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("vpc_public_ip", ip.Address)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepAttachFloatingIP) Cleanup(state multistep.StateBag) {
+	if self.floatingIpId == "" {
+		return
+	}
+
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+
+	ui.Say("Releasing floating IP...")
+	if err := client.ReleaseFloatingIP(self.floatingIpId); err != nil {
+		ui.Error(fmt.Sprintf("Error releasing floating IP: %s", err))
+	}
+}
+
+// stepCaptureVPCImage captures the instance's boot volume as a reusable VPC custom image.
+type stepCaptureVPCImage struct{}
+
+func (self *stepCaptureVPCImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	client := state.Get("vpcClient").(*VPCClient)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("vpc_instance_id").(string)
+
+	ui.Say(fmt.Sprintf("Capturing VPC image %s...", config.ImageName))
+
+	instance, err := client.GetInstance(instanceId)
+	if err != nil {
+		err = fmt.Errorf("Error fetching VPC instance: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	image, err := client.CaptureImage(config.ImageName, config.ResourceGroupId, instance.BootVolumeAttachment.Volume.Id)
+	if err != nil {
+		err = fmt.Errorf("Error capturing VPC image: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	err = waitFor(ctx, config.StateTimeout, func() (bool, error) {
+		image, err := client.GetImage(image.Id)
+		if err != nil {
+			return false, err
+		}
+		return image.Status == "available", nil
+	})
+	if err != nil {
+		err = fmt.Errorf("Error waiting for VPC image to become available: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("image_id", image.Id)
+	state.Put("image_crn", image.Crn)
+
+	return multistep.ActionContinue
+}
+
+func (self *stepCaptureVPCImage) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,52 @@
+package ibmcloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const cloudInitBootFinishedMarker = "/var/lib/cloud/instance/boot-finished"
+
+// stepWaitForCloudInit blocks until cloud-init reports it has finished
+// provisioning the instance, before the image is captured. When a
+// communicator is connected it checks over that connection; otherwise (e.g.
+// communicator = "none") it polls via the SoftLayer executeRemoteScript
+// transaction.
+type stepWaitForCloudInit struct{}
+
+func (self *stepWaitForCloudInit) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(Config)
+	ui := state.Get("ui").(packer.Ui)
+	instanceId := state.Get("instance_id").(string)
+
+	ui.Say("Waiting for cloud-init to finish...")
+
+	checkMarker := func() (bool, error) {
+		if rawComm, ok := state.GetOk("communicator"); ok {
+			comm := rawComm.(packer.Communicator)
+			cmd := &packer.RemoteCmd{Command: fmt.Sprintf("test -f %s", cloudInitBootFinishedMarker)}
+			if err := comm.Start(ctx, cmd); err != nil {
+				return false, err
+			}
+			cmd.Wait()
+			return cmd.ExitStatus() == 0, nil
+		}
+
+		client := state.Get("client").(SoftlayerClient)
+		return client.RemoteFileExists(instanceId, cloudInitBootFinishedMarker)
+	}
+
+	if err := waitFor(ctx, config.StateTimeout, checkMarker); err != nil {
+		err = fmt.Errorf("Error waiting for cloud-init: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (self *stepWaitForCloudInit) Cleanup(multistep.StateBag) {}
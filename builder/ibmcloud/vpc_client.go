@@ -0,0 +1,286 @@
+package ibmcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const iamTokenUrl = "https://iam.cloud.ibm.com/identity/token"
+const vpcApiVersion = "2022-03-29"
+
+// VPCClient talks to the IBM Cloud VPC Gen2 API on behalf of the VPC
+// builder flow, authenticating itself against IAM.
+type VPCClient struct {
+	ApiKey      string
+	Region      string
+	accessToken string
+}
+
+// New authenticates against IAM and returns a ready to use VPCClient.
+func (VPCClient) New(apiKey string, region string) (*VPCClient, error) {
+	client := &VPCClient{ApiKey: apiKey, Region: region}
+
+	if err := client.refreshToken(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (self *VPCClient) refreshToken() error {
+	form := url.Values{}
+	form.Set("grant_type", "urn:ibm:params:oauth:grant-type:apikey")
+	form.Set("apikey", self.ApiKey)
+
+	req, err := http.NewRequest("POST", iamTokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error authenticating against IAM: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("IAM token request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return fmt.Errorf("error decoding IAM token response: %s", err)
+	}
+
+	self.accessToken = tokenResp.AccessToken
+	return nil
+}
+
+func (self *VPCClient) baseUrl() string {
+	return fmt.Sprintf("https://%s.iaas.cloud.ibm.com/v1", self.Region)
+}
+
+func (self *VPCClient) do(method string, path string, body interface{}, out interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	u := fmt.Sprintf("%s%s", self.baseUrl(), path)
+	if strings.Contains(u, "?") {
+		u = fmt.Sprintf("%s&version=%s&generation=2", u, vpcApiVersion)
+	} else {
+		u = fmt.Sprintf("%s?version=%s&generation=2", u, vpcApiVersion)
+	}
+
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+self.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("VPC API request %s %s failed with status %d: %s", method, path, resp.StatusCode, respBody)
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("error decoding VPC API response: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// VPCInstance is the subset of the instance resource this builder cares about.
+type VPCInstance struct {
+	Id                      string                        `json:"id"`
+	Crn                     string                         `json:"crn"`
+	Status                  string                         `json:"status"`
+	PrimaryNetworkInterface vpcInstanceNetworkInterfaceRef `json:"primary_network_interface"`
+	BootVolumeAttachment    vpcVolumeAttachmentRef         `json:"boot_volume_attachment"`
+}
+
+type vpcInstanceNetworkInterfaceRef struct {
+	Id        string          `json:"id"`
+	PrimaryIP vpcPrimaryIPRef `json:"primary_ip"`
+}
+
+type vpcPrimaryIPRef struct {
+	Address string `json:"address"`
+}
+
+type vpcVolumeAttachmentRef struct {
+	Volume vpcVolumeRef `json:"volume"`
+}
+
+type vpcVolumeRef struct {
+	Id string `json:"id"`
+}
+
+// CreateInstance creates a new VPC virtual server instance.
+func (self *VPCClient) CreateInstance(params map[string]interface{}) (*VPCInstance, error) {
+	var instance VPCInstance
+	if err := self.do("POST", "/instances", params, &instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// GetInstance fetches the current state of an instance.
+func (self *VPCClient) GetInstance(instanceId string) (*VPCInstance, error) {
+	var instance VPCInstance
+	if err := self.do("GET", fmt.Sprintf("/instances/%s", instanceId), nil, &instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// DeleteInstance deletes a VPC instance.
+func (self *VPCClient) DeleteInstance(instanceId string) error {
+	return self.do("DELETE", fmt.Sprintf("/instances/%s", instanceId), nil, nil)
+}
+
+// VPCFloatingIP is the subset of the floating IP resource this builder cares about.
+type VPCFloatingIP struct {
+	Id      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// CreateFloatingIP reserves a new floating IP in the given zone.
+func (self *VPCClient) CreateFloatingIP(zone string) (*VPCFloatingIP, error) {
+	var ip VPCFloatingIP
+	params := map[string]interface{}{
+		"zone": map[string]string{"name": zone},
+	}
+	if err := self.do("POST", "/floating_ips", params, &ip); err != nil {
+		return nil, err
+	}
+	return &ip, nil
+}
+
+// AttachFloatingIP associates a floating IP with an instance's primary network interface.
+func (self *VPCClient) AttachFloatingIP(instanceId string, networkInterfaceId string, floatingIpId string) error {
+	path := fmt.Sprintf("/instances/%s/network_interfaces/%s/floating_ips/%s", instanceId, networkInterfaceId, floatingIpId)
+	return self.do("PUT", path, nil, nil)
+}
+
+// ReleaseFloatingIP deletes a previously reserved floating IP.
+func (self *VPCClient) ReleaseFloatingIP(floatingIpId string) error {
+	return self.do("DELETE", fmt.Sprintf("/floating_ips/%s", floatingIpId), nil, nil)
+}
+
+// VPCImage is the subset of the image resource this builder cares about.
+type VPCImage struct {
+	Id        string `json:"id"`
+	Crn       string `json:"crn"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ListImages lists images visible to the caller (both public and the
+// account's private images).
+func (self *VPCClient) ListImages() ([]VPCImage, error) {
+	var page struct {
+		Images []VPCImage `json:"images"`
+	}
+	if err := self.do("GET", "/images", nil, &page); err != nil {
+		return nil, err
+	}
+	return page.Images, nil
+}
+
+// CaptureImage creates a new custom image from the source instance's boot volume.
+func (self *VPCClient) CaptureImage(name string, resourceGroupId string, sourceVolumeId string) (*VPCImage, error) {
+	var image VPCImage
+	params := map[string]interface{}{
+		"name":           name,
+		"resource_group": map[string]string{"id": resourceGroupId},
+		"source_volume":  map[string]string{"id": sourceVolumeId},
+	}
+	if err := self.do("POST", "/images", params, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// GetImage fetches the current state of a custom image.
+func (self *VPCClient) GetImage(imageId string) (*VPCImage, error) {
+	var image VPCImage
+	if err := self.do("GET", fmt.Sprintf("/images/%s", imageId), nil, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// VPCImageExportJob is the subset of the image export job resource this
+// builder cares about. Status is one of "queued", "running", "succeeded" or
+// "failed".
+type VPCImageExportJob struct {
+	Id            string                    `json:"id"`
+	Status        string                    `json:"status"`
+	Format        string                    `json:"format"`
+	StorageObject vpcExportStorageObjectRef `json:"storage_object"`
+}
+
+type vpcExportStorageObjectRef struct {
+	Bucket string `json:"bucket"`
+	Name   string `json:"name"`
+}
+
+// CreateImageExportJob starts an export of the image's disk into the given
+// Cloud Object Storage bucket, in qcow2 format.
+func (self *VPCClient) CreateImageExportJob(imageId string, bucket string) (*VPCImageExportJob, error) {
+	var job VPCImageExportJob
+	params := map[string]interface{}{
+		"format":         "qcow2",
+		"storage_bucket": map[string]string{"name": bucket},
+	}
+	if err := self.do("POST", fmt.Sprintf("/images/%s/export_jobs", imageId), params, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetImageExportJob fetches the current state of an image export job.
+func (self *VPCClient) GetImageExportJob(imageId string, jobId string) (*VPCImageExportJob, error) {
+	var job VPCImageExportJob
+	path := fmt.Sprintf("/images/%s/export_jobs/%s", imageId, jobId)
+	if err := self.do("GET", path, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
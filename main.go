@@ -5,6 +5,7 @@ import (
 
 	"github.com/hashicorp/packer-plugin-sdk/plugin"
 	"github.com/ibmcloud/packer-builder-ibmcloud/builder/ibmcloud"
+	ibmcloudexport "github.com/ibmcloud/packer-builder-ibmcloud/post-processor/ibmcloud-export"
 	"github.com/ibmcloud/packer-builder-ibmcloud/version"
 )
 
@@ -13,6 +14,7 @@ func main() {
 
 	pps := plugin.NewSet()
 	pps.RegisterBuilder(plugin.DEFAULT_NAME, new(ibmcloud.Builder))
+	pps.RegisterPostProcessor("ibmcloud-export", new(ibmcloudexport.PostProcessor))
 	err := pps.Run()
 	if err != nil {
 		panic(err)
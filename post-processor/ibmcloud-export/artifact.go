@@ -0,0 +1,82 @@
+package ibmcloudexport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Artifact represents an image exported to IBM Cloud Object Storage. When
+// the source image was distributed to multiple datacenters, it holds one
+// exported object per datacenter instead of a single url/sha256sum pair.
+type Artifact struct {
+	url       string
+	sha256sum string
+
+	// regionExports maps datacenter -> exported object, populated when the
+	// source artifact's image was distributed to multiple datacenters.
+	regionExports map[string]regionExport
+}
+
+type regionExport struct {
+	url       string
+	sha256sum string
+}
+
+func (self *Artifact) BuilderId() string {
+	return BuilderId
+}
+
+func (self *Artifact) Files() []string {
+	if len(self.regionExports) == 0 {
+		return []string{self.url}
+	}
+
+	files := make([]string, 0, len(self.regionExports))
+	for _, export := range self.regionExports {
+		files = append(files, export.url)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// Id returns the exported object's URL. When the source image was
+// distributed to multiple datacenters, it returns every "datacenter:url"
+// pair instead, sorted by datacenter name, mirroring ibmcloud.Artifact.Id.
+func (self *Artifact) Id() string {
+	if len(self.regionExports) == 0 {
+		return self.url
+	}
+
+	pairs := make([]string, 0, len(self.regionExports))
+	for datacenter, export := range self.regionExports {
+		pairs = append(pairs, fmt.Sprintf("%s:%s", datacenter, export.url))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (self *Artifact) String() string {
+	if len(self.regionExports) == 0 {
+		return fmt.Sprintf("%s (sha256:%s)", self.url, self.sha256sum)
+	}
+
+	return self.Id()
+}
+
+func (self *Artifact) State(name string) interface{} {
+	switch name {
+	case "url":
+		return self.url
+	case "sha256sum":
+		return self.sha256sum
+	}
+
+	return nil
+}
+
+func (self *Artifact) Destroy() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package ibmcloudexport
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ibmcloud/packer-builder-ibmcloud/builder/ibmcloud"
+)
+
+// COSClient exports a captured image's external source into IBM Cloud
+// Object Storage, using the bucket's HMAC credentials to authenticate.
+type COSClient struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+
+	// Platform selects how the image's disk is sourced: PLATFORM_CLASSIC
+	// streams it from SoftLayer's external-source export job and uploads it
+	// here itself, while PLATFORM_VPC asks the VPC API to export it straight
+	// into the bucket (Endpoint is not used for this). Defaults to
+	// PLATFORM_CLASSIC.
+	Platform string
+
+	// SoftLayer classic credentials, required when Platform == PLATFORM_CLASSIC.
+	SoftlayerUsername string
+	SoftlayerApiKey   string
+
+	// VpcClient is required when Platform == PLATFORM_VPC.
+	VpcClient *ibmcloud.VPCClient
+}
+
+func (COSClient) New(endpoint string, accessKey string, secretKey string) *COSClient {
+	return &COSClient{Endpoint: endpoint, AccessKey: accessKey, SecretKey: secretKey}
+}
+
+// ExportImage copies the image's captured disk into the given bucket/key and
+// returns the SHA256 of the uploaded object. On PLATFORM_VPC the VPC API
+// exports the image directly into the bucket, so no checksum is computed on
+// our end and an empty string is returned instead; objectKey is not used
+// there, and the exported object is named by the VPC export job.
+func (self *COSClient) ExportImage(ctx context.Context, imageId string, bucket string, objectKey string, timeout time.Duration) (string, error) {
+	if self.Platform == ibmcloud.PLATFORM_VPC {
+		return "", self.exportVPCImage(ctx, imageId, bucket, timeout)
+	}
+
+	return self.exportClassicImage(imageId, bucket, objectKey)
+}
+
+func (self *COSClient) exportClassicImage(imageId string, bucket string, objectKey string) (string, error) {
+	source, err := self.requestExternalSource(imageId)
+	if err != nil {
+		return "", fmt.Errorf("error requesting external source for image %s: %s", imageId, err)
+	}
+	defer source.Close()
+
+	hasher := sha256.New()
+	body := io.TeeReader(source, hasher)
+
+	url := fmt.Sprintf("https://%s/%s/%s", self.Endpoint, bucket, objectKey)
+	req, err := http.NewRequest("PUT", url, body)
+	if err != nil {
+		return "", err
+	}
+	self.sign(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("COS upload failed with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// requestExternalSource opens a streaming read of the image's disk as
+// exposed by SoftLayer's createFromExternalSource/copyToExternalSource
+// export job, authenticating with the classic API's username and API key.
+func (self *COSClient) requestExternalSource(imageId string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.softlayer.com/rest/v3/SoftLayer_Virtual_Guest_Block_Device_Template_Group/%s/copyToExternalSource", imageId), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(self.SoftlayerUsername, self.SoftlayerApiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("export job request failed with status %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// exportVPCImage asks the VPC API to export the image's disk straight into
+// the given Object Storage bucket and waits for the export job to reach
+// "succeeded", same as the ibmcloud builder's own long-polls.
+func (self *COSClient) exportVPCImage(ctx context.Context, imageId string, bucket string, timeout time.Duration) error {
+	job, err := self.VpcClient.CreateImageExportJob(imageId, bucket)
+	if err != nil {
+		return fmt.Errorf("error creating VPC image export job: %s", err)
+	}
+
+	err = waitFor(ctx, timeout, func() (bool, error) {
+		job, err = self.VpcClient.GetImageExportJob(imageId, job.Id)
+		if err != nil {
+			return false, err
+		}
+		if job.Status == "failed" {
+			return false, fmt.Errorf("VPC image export job %s failed", job.Id)
+		}
+		return job.Status == "succeeded", nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for VPC image export job to finish: %s", err)
+	}
+
+	return nil
+}
+
+// sign applies an HMAC-SHA256 signature of the request to the Authorization
+// header, as required by IBM COS's HMAC authentication scheme.
+func (self *COSClient) sign(req *http.Request) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Date", date)
+
+	toSign := fmt.Sprintf("%s\n%s\n%s", req.Method, req.URL.Path, date)
+	mac := hmac.New(sha256.New, []byte(self.SecretKey))
+	mac.Write([]byte(toSign))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 Credential=%s, Signature=%s", self.AccessKey, signature))
+}
@@ -0,0 +1,220 @@
+//go:generate mapstructure-to-hcl2 -type Config
+package ibmcloudexport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+
+	"github.com/ibmcloud/packer-builder-ibmcloud/builder/ibmcloud"
+)
+
+// BuilderId identifies the artifacts produced by this post-processor.
+const BuilderId = "packer.post-processor.ibmcloud-export"
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	CosEndpoint       string `mapstructure:"cos_endpoint"`
+	CosBucket         string `mapstructure:"cos_bucket"`
+	CosAccessKey      string `mapstructure:"cos_access_key"`
+	CosSecretKey      string `mapstructure:"cos_secret_key"`
+	ObjectKeyFormat   string `mapstructure:"object_key_format"`
+	KeepInputArtifact bool   `mapstructure:"keep_input_artifact"`
+
+	// Platform selects how the source image is exported: the classic
+	// SoftLayer infrastructure or the VPC Gen2 infrastructure. Defaults to
+	// PLATFORM_CLASSIC, matching the ibmcloud builder.
+	Platform string `mapstructure:"platform"`
+
+	// SoftLayer classic credentials, required when Platform == PLATFORM_CLASSIC.
+	SoftlayerUsername string `mapstructure:"softlayer_username"`
+	SoftlayerApiKey   string `mapstructure:"softlayer_api_key"`
+
+	// VPC credentials, required when Platform == PLATFORM_VPC.
+	IBMCloudApiKey string `mapstructure:"ibmcloud_api_key"`
+	Region         string `mapstructure:"region"`
+
+	// RawExportTimeout bounds how long we wait on PLATFORM_VPC for the VPC
+	// image export job to reach "succeeded". Defaults to "30m".
+	RawExportTimeout string `mapstructure:"export_timeout"`
+	ExportTimeout    time.Duration
+
+	ctx interpolate.Context
+}
+
+// PostProcessor exports an image captured by the ibmcloud builder to IBM
+// Cloud Object Storage so it can be downloaded or reused outside of the
+// native image registry.
+type PostProcessor struct {
+	config Config
+}
+
+func (self *PostProcessor) ConfigSpec() hcldec.ObjectSpec {
+	return self.config.FlatMapstructure().HCL2Spec()
+}
+
+func (self *PostProcessor) Configure(raws ...interface{}) error {
+	err := config.Decode(&self.config, &config.DecodeOpts{
+		Interpolate:        true,
+		InterpolateContext: &self.config.ctx,
+		InterpolateFilter:  &interpolate.RenderFilter{},
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	if self.config.ObjectKeyFormat == "" {
+		self.config.ObjectKeyFormat = "{{.BuildName}}/{{.ImageId}}.vhd"
+	}
+
+	if self.config.Platform == "" {
+		self.config.Platform = ibmcloud.PLATFORM_CLASSIC
+	}
+
+	if self.config.RawExportTimeout == "" {
+		self.config.RawExportTimeout = "30m"
+	}
+
+	var errs *packer.MultiError
+
+	if self.config.CosEndpoint == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("cos_endpoint must be specified"))
+	}
+
+	if self.config.CosBucket == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("cos_bucket must be specified"))
+	}
+
+	if self.config.CosAccessKey == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("cos_access_key must be specified"))
+	}
+
+	if self.config.CosSecretKey == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("cos_secret_key must be specified"))
+	}
+
+	switch self.config.Platform {
+	case ibmcloud.PLATFORM_VPC:
+		if self.config.IBMCloudApiKey == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("ibmcloud_api_key must be specified when platform is vpc"))
+		}
+		if self.config.Region == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("region must be specified when platform is vpc"))
+		}
+	case ibmcloud.PLATFORM_CLASSIC:
+		if self.config.SoftlayerUsername == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("softlayer_username must be specified when platform is classic"))
+		}
+		if self.config.SoftlayerApiKey == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("softlayer_api_key must be specified when platform is classic"))
+		}
+	default:
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("platform must be %q or %q, got %q",
+			ibmcloud.PLATFORM_CLASSIC, ibmcloud.PLATFORM_VPC, self.config.Platform))
+	}
+
+	exportTimeout, err := time.ParseDuration(self.config.RawExportTimeout)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("failed parsing export_timeout: %s", err))
+	}
+	self.config.ExportTimeout = exportTimeout
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (self *PostProcessor) PostProcess(ctx context.Context, ui packer.Ui, source packer.Artifact) (packer.Artifact, bool, bool, error) {
+	if source.BuilderId() != ibmcloud.BuilderId {
+		return nil, false, false, fmt.Errorf(
+			"unsupported artifact type %q: this post-processor only works with artifacts from the ibmcloud builder", source.BuilderId())
+	}
+
+	client := COSClient{}.New(self.config.CosEndpoint, self.config.CosAccessKey, self.config.CosSecretKey)
+	client.Platform = self.config.Platform
+	client.SoftlayerUsername = self.config.SoftlayerUsername
+	client.SoftlayerApiKey = self.config.SoftlayerApiKey
+	if self.config.Platform == ibmcloud.PLATFORM_VPC {
+		vpcClient, err := ibmcloud.VPCClient{}.New(self.config.IBMCloudApiKey, self.config.Region)
+		if err != nil {
+			return nil, false, false, fmt.Errorf("error authenticating against IAM: %s", err)
+		}
+		client.VpcClient = vpcClient
+	}
+
+	images := regionImages(source)
+	if len(images) == 1 {
+		if imageId, ok := images[""]; ok {
+			artifact, err := self.exportOne(ctx, ui, client, imageId)
+			if err != nil {
+				return nil, false, false, err
+			}
+			return artifact, self.config.KeepInputArtifact, false, nil
+		}
+	}
+
+	regionExports := make(map[string]regionExport, len(images))
+	for datacenter, imageId := range images {
+		exported, err := self.exportOne(ctx, ui, client, imageId)
+		if err != nil {
+			return nil, false, false, err
+		}
+		regionExports[datacenter] = regionExport{url: exported.url, sha256sum: exported.sha256sum}
+	}
+
+	return &Artifact{regionExports: regionExports}, self.config.KeepInputArtifact, false, nil
+}
+
+// regionImages returns the image IDs to export, keyed by datacenter. When
+// the source artifact was distributed to multiple datacenters (see the
+// ibmcloud builder's upload_to_datacenters), its per-region metadata is
+// used instead of the artifact's Id(), which would otherwise return a
+// single composite "datacenter:id,datacenter:id" string.
+func regionImages(source packer.Artifact) map[string]string {
+	if metadata, ok := source.State("atlas.artifact.metadata").(map[string]interface{}); ok {
+		if regions, ok := metadata["regions"].(map[string]map[string]string); ok && len(regions) > 0 {
+			images := make(map[string]string, len(regions))
+			for datacenter, region := range regions {
+				images[datacenter] = region["image_id"]
+			}
+			return images
+		}
+	}
+
+	return map[string]string{"": source.Id()}
+}
+
+func (self *PostProcessor) exportOne(ctx context.Context, ui packer.Ui, client *COSClient, imageId string) (*Artifact, error) {
+	self.config.ctx.Data = &exportObjectKeyData{ImageId: imageId, BuildName: self.config.PackerBuildName}
+	objectKey, err := interpolate.Render(self.config.ObjectKeyFormat, &self.config.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering object_key_format: %s", err)
+	}
+
+	ui.Say(fmt.Sprintf("Exporting image %s to cos://%s/%s...", imageId, self.config.CosBucket, objectKey))
+
+	sha256sum, err := client.ExportImage(ctx, imageId, self.config.CosBucket, objectKey, self.config.ExportTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting image to Cloud Object Storage: %s", err)
+	}
+
+	return &Artifact{
+		url:       fmt.Sprintf("s3://%s/%s", self.config.CosBucket, objectKey),
+		sha256sum: sha256sum,
+	}, nil
+}
+
+type exportObjectKeyData struct {
+	ImageId   string
+	BuildName string
+}
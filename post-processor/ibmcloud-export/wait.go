@@ -0,0 +1,35 @@
+package ibmcloudexport
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// waitFor polls check every two seconds until it returns true, ctx is
+// cancelled, or timeout elapses. Mirrors the ibmcloud builder's helper of
+// the same name/shape, duplicated here since it's unexported in that
+// package.
+func waitFor(ctx context.Context, timeout time.Duration, check func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timeout while waiting for condition")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}